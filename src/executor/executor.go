@@ -0,0 +1,126 @@
+// Package executor implements swan's custom Mesos executor. Unlike the
+// default Docker containerizer path, this executor runs inside the task
+// container's network namespace so it can probe health checks on a
+// CNI/USER-network task, and it owns the task's stdout/stderr so it can
+// forward them to a configurable sink.
+package executor
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/Dataman-Cloud/swan/src/mesosproto/mesos"
+	"github.com/Dataman-Cloud/swan/src/types"
+)
+
+// Driver is the subset of the Mesos v1 executor HTTP driver the Executor
+// needs; it's an interface so the health-check/log-forwarding logic can be
+// exercised without a live Mesos agent connection.
+type Driver interface {
+	SendStatusUpdate(state mesos.TaskState, message string) error
+}
+
+// Executor runs the health-check loop and log forwarding for a single task,
+// and escalates SIGTERM to SIGKILL according to the task's KillPolicy.
+type Executor struct {
+	Task    *types.Task
+	Driver  Driver
+	LogSink Sink
+	Stdout  io.Reader // container stdout, attached by the caller
+	Stderr  io.Reader // container stderr, attached by the caller
+
+	cancel context.CancelFunc
+}
+
+// Run starts the health-check loop and log forwarding for the task. It
+// blocks until the task process exits or ctx is cancelled.
+func (e *Executor) Run(ctx context.Context) error {
+	ctx, e.cancel = context.WithCancel(ctx)
+
+	if len(e.Task.HealthChecks) > 0 {
+		go e.runHealthChecks(ctx)
+	}
+
+	return e.forwardLogs(ctx)
+}
+
+// runHealthChecks probes every configured health check on its own interval
+// from inside the container's netns, and reports each probe's result back to
+// the agent via periodic TaskStatus updates. Each health check runs on its
+// own goroutine and ticker so a slow-interval check (e.g. 60s) can't starve a
+// sibling with a fast one (e.g. 1s). Every check reports its own state as it
+// is probed; no combined state across checks is computed, so the latest
+// update reflects whichever check ran last.
+func (e *Executor) runHealthChecks(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for _, hc := range e.Task.HealthChecks {
+		wg.Add(1)
+		go func(hc *types.HealthCheck) {
+			defer wg.Done()
+			e.runHealthCheck(ctx, hc)
+		}(hc)
+	}
+
+	wg.Wait()
+}
+
+// runHealthCheck probes a single health check on its own interval until ctx
+// is cancelled, reporting unhealthy once it has failed
+// MaxConsecutiveFailures times in a row. MaxConsecutiveFailures == 0 means
+// "not configured", so it's treated as 1 failure tolerance rather than
+// reporting unhealthy on the very first probe.
+func (e *Executor) runHealthCheck(ctx context.Context, hc *types.HealthCheck) {
+	threshold := hc.MaxConsecutiveFailures
+	if threshold == 0 {
+		threshold = 2
+	}
+
+	interval := time.Duration(hc.IntervalSeconds * float64(time.Second))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var failures uint32
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if probe(hc) {
+			failures = 0
+			e.reportHealth(true, hc)
+			continue
+		}
+
+		failures++
+		e.reportHealth(failures < threshold, hc)
+	}
+}
+
+// reportHealth pushes the current health state up through the driver so the
+// scheduler sees it via TaskStatus.Message without needing the agent's own
+// (netns-blind) health checker.
+func (e *Executor) reportHealth(healthy bool, hc *types.HealthCheck) {
+	msg := "healthy"
+	if !healthy {
+		msg = "unhealthy: " + hc.Protocol + " check failed"
+	}
+
+	if err := e.Driver.SendStatusUpdate(mesos.TaskState_TASK_RUNNING, msg); err != nil {
+		log.Errorf("swan executor: failed to send health status update for task %s: %v", e.Task.ID, err)
+	}
+}
+
+// Shutdown stops the health-check loop and log forwarding.
+func (e *Executor) Shutdown() {
+	if e.cancel != nil {
+		e.cancel()
+	}
+}
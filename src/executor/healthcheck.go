@@ -0,0 +1,51 @@
+package executor
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/src/types"
+)
+
+// probe runs a single HTTP or TCP health check against localhost inside the
+// container's own netns and reports whether it passed.
+func probe(hc *types.HealthCheck) bool {
+	timeout := time.Duration(hc.TimeoutSeconds * float64(time.Second))
+
+	switch strings.ToLower(hc.Protocol) {
+	case "http":
+		return probeHTTP(hc, timeout)
+	case "tcp":
+		return probeTCP(hc, timeout)
+	default:
+		return true
+	}
+}
+
+func probeHTTP(hc *types.HealthCheck, timeout time.Duration) bool {
+	client := &http.Client{Timeout: timeout}
+
+	url := fmt.Sprintf("http://127.0.0.1:%d%s", hc.Port, hc.Path)
+	resp, err := client.Get(url)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK
+}
+
+func probeTCP(hc *types.HealthCheck, timeout time.Duration) bool {
+	addr := fmt.Sprintf("127.0.0.1:%d", hc.Port)
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+
+	return true
+}
@@ -0,0 +1,79 @@
+package executor
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/Dataman-Cloud/swan/src/types"
+)
+
+// Kill runs the task's pre-stop hook, if any, then sends it KillPolicy.Signal
+// (SIGTERM by default), escalating to SIGKILL if the process hasn't exited
+// within KillPolicy.Duration. pid is the task's container PID as seen from
+// the executor's netns.
+func (e *Executor) Kill(pid int) error {
+	kp := e.Task.KillPolicy
+
+	if kp != nil && kp.PreStopHook != "" {
+		if err := exec.Command("sh", "-c", kp.PreStopHook).Run(); err != nil {
+			log.Warnf("swan executor: pre-stop hook for task %s failed: %v", e.Task.ID, err)
+		}
+	}
+
+	sig := killSignal(kp)
+	log.Infof("swan executor: sending %s to task %s (pid %d)", sig, e.Task.ID, pid)
+	if err := syscall.Kill(pid, sig); err != nil {
+		return err
+	}
+
+	grace := killGracePeriod(kp)
+	if grace <= 0 {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for syscall.Kill(pid, 0) == nil {
+			time.Sleep(200 * time.Millisecond)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(grace):
+		log.Warnf("swan executor: task %s did not exit within grace period, sending SIGKILL", e.Task.ID)
+		return syscall.Kill(pid, syscall.SIGKILL)
+	}
+}
+
+// killGracePeriod converts KillPolicy.Duration (milliseconds, matching
+// Scheduler.KillTask's GracePeriod encoding) to a time.Duration.
+func killGracePeriod(kp *types.KillPolicy) time.Duration {
+	if kp == nil || kp.Duration == 0 {
+		return 0
+	}
+	return time.Duration(kp.Duration) * time.Millisecond
+}
+
+// killSignal maps KillPolicy.Signal to the syscall.Signal Kill should send,
+// defaulting to SIGTERM for an empty or unrecognized value so a task without
+// an opinion still shuts down gracefully instead of failing to kill at all.
+func killSignal(kp *types.KillPolicy) syscall.Signal {
+	if kp == nil {
+		return syscall.SIGTERM
+	}
+
+	switch kp.Signal {
+	case "SIGINT":
+		return syscall.SIGINT
+	case "SIGQUIT":
+		return syscall.SIGQUIT
+	default:
+		return syscall.SIGTERM
+	}
+}
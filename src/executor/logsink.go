@@ -0,0 +1,116 @@
+package executor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Sink receives a single forwarded log line from the task's stdout/stderr.
+type Sink interface {
+	Write(stream, line string) error
+}
+
+// FileSink appends forwarded lines to a local file.
+type FileSink struct {
+	f *os.File
+}
+
+// NewFileSink opens (creating if necessary) path for appending forwarded logs.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{f: f}, nil
+}
+
+func (s *FileSink) Write(stream, line string) error {
+	_, err := fmt.Fprintf(s.f, "[%s] %s\n", stream, line)
+	return err
+}
+
+// SyslogSink forwards lines to the local syslog daemon.
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink dials the local syslog daemon, tagging entries with tag.
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	w, err := syslog.New(syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+func (s *SyslogSink) Write(stream, line string) error {
+	return s.w.Info(fmt.Sprintf("[%s] %s", stream, line))
+}
+
+// AggregatorSink forwards lines to a swan log aggregator over an arbitrary
+// io.Writer (e.g. a TCP or HTTP connection the caller has already dialed).
+type AggregatorSink struct {
+	w io.Writer
+}
+
+// NewAggregatorSink wraps an already-connected writer as a log sink.
+func NewAggregatorSink(w io.Writer) *AggregatorSink {
+	return &AggregatorSink{w: w}
+}
+
+func (s *AggregatorSink) Write(stream, line string) error {
+	_, err := fmt.Fprintf(s.w, "%s %s\n", stream, line)
+	return err
+}
+
+// forwardLogs copies stdout/stderr lines to the configured sink until both
+// streams reach EOF or ctx is cancelled.
+func (e *Executor) forwardLogs(ctx context.Context) error {
+	if e.LogSink == nil || (e.Stdout == nil && e.Stderr == nil) {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	var wg sync.WaitGroup
+
+	forward := func(stream string, r io.Reader) {
+		defer wg.Done()
+		if r == nil {
+			return
+		}
+
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := e.LogSink.Write(stream, scanner.Text()); err != nil {
+				log.Errorf("swan executor: failed to forward %s log line for task %s: %v", stream, e.Task.ID, err)
+			}
+		}
+	}
+
+	wg.Add(2)
+	go forward("stdout", e.Stdout)
+	go forward("stderr", e.Stderr)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
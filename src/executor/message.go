@@ -0,0 +1,11 @@
+package executor
+
+// KillMessage is the JSON payload the scheduler sends as a framework MESSAGE
+// call ahead of KILL, carrying the parts of a KillPolicy Mesos's own
+// KillPolicy proto can't express. See Scheduler.sendKillMessage. Executor.Kill
+// reads Signal and PreStopHook from its Task's KillPolicy; nothing in this
+// package decodes a KillMessage.
+type KillMessage struct {
+	Signal      string `json:"signal,omitempty"`
+	PreStopHook string `json:"pre_stop_hook,omitempty"`
+}
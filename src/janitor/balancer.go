@@ -0,0 +1,174 @@
+package janitor
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync/atomic"
+)
+
+// LBAlgoLabel is the per-app label used to select an upstream's load-balancing
+// algorithm, e.g. SWAN_LB_ALGO=leastconn.
+const LBAlgoLabel = "SWAN_LB_ALGO"
+
+// supported load-balancing algorithm names, for use with LBAlgoLabel.
+const (
+	AlgoWeight     = "weight"
+	AlgoRoundRobin = "roundrobin"
+	AlgoLeastConn  = "leastconn"
+	AlgoRandom     = "random"
+	AlgoIPHash     = "iphash"
+	AlgoP2C        = "p2c"
+)
+
+// Balancer selects one of an upstream's live targets to serve the next request.
+// remoteIP is supplied for algorithms (e.g. IPHashBalancer) that need a stable
+// key to hash on; algorithms that don't care about it may ignore it.
+type Balancer interface {
+	Next(targets []*Target, remoteIP string) *Target
+}
+
+var balancers = map[string]func() Balancer{
+	AlgoWeight:     func() Balancer { return &WeightBalancer{} },
+	AlgoRoundRobin: func() Balancer { return &RoundRobinBalancer{} },
+	AlgoLeastConn:  func() Balancer { return &LeastConnBalancer{} },
+	AlgoRandom:     func() Balancer { return &RandomBalancer{} },
+	AlgoIPHash:     func() Balancer { return &IPHashBalancer{} },
+	AlgoP2C:        func() Balancer { return &P2CBalancer{} },
+}
+
+// RegisterBalancer makes a load-balancing algorithm available under algo, so
+// an upstream can select it via the LBAlgoLabel app label. Intended to be
+// called from init() by callers shipping a custom algorithm.
+func RegisterBalancer(algo string, factory func() Balancer) {
+	balancers[algo] = factory
+}
+
+// newBalancer builds the Balancer named by algo, falling back to the default
+// weight-based balancer for an unknown or empty name.
+func newBalancer(algo string) Balancer {
+	if factory, ok := balancers[algo]; ok {
+		return factory()
+	}
+	return &WeightBalancer{}
+}
+
+// WeightBalancer picks targets at random, proportional to Target.Weight. It is
+// the default balancer when no LBAlgoLabel is set.
+type WeightBalancer struct{}
+
+func (b *WeightBalancer) Next(targets []*Target, _ string) *Target {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	var total float64
+	for _, t := range targets {
+		total += t.Weight
+	}
+
+	if total <= 0 {
+		return targets[rand.Intn(len(targets))]
+	}
+
+	r := rand.Float64() * total
+	for _, t := range targets {
+		r -= t.Weight
+		if r <= 0 {
+			return t
+		}
+	}
+
+	return targets[len(targets)-1]
+}
+
+// RoundRobinBalancer cycles through targets in order.
+type RoundRobinBalancer struct {
+	counter uint64
+}
+
+func (b *RoundRobinBalancer) Next(targets []*Target, _ string) *Target {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	i := atomic.AddUint64(&b.counter, 1)
+	return targets[int(i)%len(targets)]
+}
+
+// RandomBalancer picks a target uniformly at random.
+type RandomBalancer struct{}
+
+func (b *RandomBalancer) Next(targets []*Target, _ string) *Target {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	return targets[rand.Intn(len(targets))]
+}
+
+// LeastConnBalancer picks the target with the fewest live connections.
+type LeastConnBalancer struct{}
+
+func (b *LeastConnBalancer) Next(targets []*Target, _ string) *Target {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	best := targets[0]
+	for _, t := range targets[1:] {
+		if t.Conns() < best.Conns() {
+			best = t
+		}
+	}
+
+	return best
+}
+
+// IPHashBalancer deterministically maps a remote IP onto a target, giving
+// clients a stable backend without requiring session storage.
+type IPHashBalancer struct{}
+
+func (b *IPHashBalancer) Next(targets []*Target, remoteIP string) *Target {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(remoteIP))
+	return targets[int(h.Sum32())%len(targets)]
+}
+
+// P2CBalancer implements power-of-two-choices: it samples two targets at
+// random and picks the one with the lower load, where load combines live
+// connection count and moving-average response latency. This converges to
+// near-optimal load distribution without the coordination cost of scanning
+// every target on each request.
+type P2CBalancer struct{}
+
+func (b *P2CBalancer) Next(targets []*Target, _ string) *Target {
+	n := len(targets)
+	if n == 0 {
+		return nil
+	}
+	if n == 1 {
+		return targets[0]
+	}
+
+	i := rand.Intn(n)
+	j := rand.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+
+	a, c := targets[i], targets[j]
+	if p2cScore(a) <= p2cScore(c) {
+		return a
+	}
+	return c
+}
+
+// p2cScore weighs connection count heavily, with latency as a tie-breaker
+// between similarly-loaded targets.
+func p2cScore(t *Target) float64 {
+	return float64(t.Conns())*1000 + t.AvgLatency()
+}
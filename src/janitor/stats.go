@@ -0,0 +1,56 @@
+package janitor
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// targetStats is the per-target snapshot exposed by StatsHandler.
+type targetStats struct {
+	TaskID     string  `json:"task_id"`
+	TaskIP     string  `json:"task_ip"`
+	TaskPort   uint32  `json:"task_port"`
+	Weight     float64 `json:"weight"`
+	Conns      int64   `json:"conns"`
+	AvgLatency float64 `json:"avg_latency_ms"`
+}
+
+// upstreamStats is the per-upstream snapshot exposed by StatsHandler.
+type upstreamStats struct {
+	AppID   string        `json:"app_id"`
+	Algo    string        `json:"algo"`
+	Targets []targetStats `json:"targets"`
+}
+
+// StatsHandler serves a JSON snapshot of every upstream's current balancer
+// algorithm and per-target connection/latency stats. Intended to be mounted
+// on the admin HTTP server, e.g. GET /lb/stats.
+func (us *Upstreams) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	us.RLock()
+	defer us.RUnlock()
+
+	ret := make([]upstreamStats, 0, len(us.Upstreams))
+	for _, u := range us.Upstreams {
+		stat := upstreamStats{
+			AppID:   u.AppID,
+			Algo:    u.algo,
+			Targets: make([]targetStats, 0, len(u.Targets)),
+		}
+
+		for _, t := range u.Targets {
+			stat.Targets = append(stat.Targets, targetStats{
+				TaskID:     t.TaskID,
+				TaskIP:     t.TaskIP,
+				TaskPort:   t.TaskPort,
+				Weight:     t.Weight,
+				Conns:      t.Conns(),
+				AvgLatency: t.AvgLatency(),
+			})
+		}
+
+		ret = append(ret, stat)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ret)
+}
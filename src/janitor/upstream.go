@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net/url"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -17,16 +19,18 @@ type Upstream struct {
 	AppID    string    `json:"app_id"` // uniq id of upstream
 	AppAlias string    `json:"app_alias"`
 	Targets  []*Target `json:"targets"`
+	algo     string    // load-balancing algorithm name, see LBAlgoLabel
 	sessions *Sessions
 	balancer Balancer
 }
 
-func newUpstream(appID, appAlias string) *Upstream {
+func newUpstream(appID, appAlias, algo string) *Upstream {
 	return &Upstream{
 		AppID:    appID,
 		AppAlias: appAlias,
 		Targets:  make([]*Target, 0, 0),
-		balancer: &WeightBalancer{}, // default balancer
+		algo:     algo,
+		balancer: newBalancer(algo), // algorithm selected by the app's SWAN_LB_ALGO label
 		sessions: newSessions(),     // sessions store
 	}
 }
@@ -64,7 +68,7 @@ func (us *Upstreams) addTarget(target *Target) {
 
 	_, u := us.getUpstreamByID(appID)
 	if u == nil { // add new upstream
-		u = newUpstream(appID, appAlias)
+		u = newUpstream(appID, appAlias, target.LBAlgo)
 		u.Targets = append(u.Targets, target)
 		us.Upstreams = append(us.Upstreams, u)
 		return
@@ -72,7 +76,7 @@ func (us *Upstreams) addTarget(target *Target) {
 
 	_, t := u.getTarget(taskID)
 	if t != nil {
-		log.Warnf("already exists the target %v, ignore.", *t)
+		log.Warnf("already exists the target %v, ignore.", t)
 		return
 	}
 
@@ -145,7 +149,18 @@ func (us *Upstreams) updateTarget(new *Target) {
 		return
 	}
 
-	t.Weight = new.Weight // NOTE only update weight currently
+	// Each field is only applied when the caller actually set it, so e.g. an
+	// IP-only update (CNI address arriving on a status update) doesn't
+	// clobber the weight a previous, separate update already applied.
+	if new.TaskIP != "" {
+		t.TaskIP = new.TaskIP
+	}
+	if new.TaskPort != 0 {
+		t.TaskPort = new.TaskPort
+	}
+	if new.Weight != 0 {
+		t.Weight = new.Weight
+	}
 }
 
 // lookup similar as lookup, but by app alias
@@ -191,11 +206,15 @@ func (us *Upstreams) lookup(remoteIP, appID, taskID string) *Target {
 	}
 
 	// use balancer to obtain a new backend
-	t = us.nextTarget(appID)
+	t = us.nextTarget(appID, remoteIP)
 	return t
 }
 
-func (us *Upstreams) nextTarget(appID string) *Target {
+// nextTarget asks the upstream's configured balancer for the next backend.
+// remoteIP is passed through for algorithms (e.g. iphash) that key on it;
+// session stickiness above already covers the common case, so non-hash
+// algorithms remain compatible even though they ignore remoteIP.
+func (us *Upstreams) nextTarget(appID, remoteIP string) *Target {
 	us.RLock()
 	defer us.RUnlock()
 
@@ -204,7 +223,30 @@ func (us *Upstreams) nextTarget(appID string) *Target {
 		return nil
 	}
 
-	return u.balancer.Next(u.Targets)
+	return u.balancer.Next(u.Targets, remoteIP)
+}
+
+// IncConn records a new in-flight connection to the given target, so
+// least-conn and P2C balancers can factor it into future selections.
+func (us *Upstreams) IncConn(appID, taskID string) {
+	if t := us.getTarget(appID, taskID); t != nil {
+		t.IncConn()
+	}
+}
+
+// DecConn releases an in-flight connection from the given target.
+func (us *Upstreams) DecConn(appID, taskID string) {
+	if t := us.getTarget(appID, taskID); t != nil {
+		t.DecConn()
+	}
+}
+
+// Observe folds a single response latency into the target's moving average,
+// used by the P2C balancer to favor fast-responding targets.
+func (us *Upstreams) Observe(appID, taskID string, d time.Duration) {
+	if t := us.getTarget(appID, taskID); t != nil {
+		t.Observe(d)
+	}
 }
 
 // note: must be called under protection of mutext lock
@@ -248,9 +290,52 @@ type Target struct {
 	TaskPort   uint32  `json:"task_port"`
 	PortName   string  `json:"port_name"`
 	Weight     float64 `json:"weihgt"`
+	LBAlgo     string  `json:"lb_algo"` // app's SWAN_LB_ALGO label, applied to the owning upstream
+
+	conns        int64      // live connection count, accessed atomically
+	latencyMu    sync.Mutex // guards avgLatencyMs
+	avgLatencyMs float64    // exponential moving average response latency, in milliseconds
+}
+
+// latencyEWMAWeight is the smoothing factor applied to each new latency sample.
+const latencyEWMAWeight = 0.2
+
+// IncConn records a new in-flight connection to this target.
+func (t *Target) IncConn() {
+	atomic.AddInt64(&t.conns, 1)
+}
+
+// DecConn releases an in-flight connection from this target.
+func (t *Target) DecConn() {
+	atomic.AddInt64(&t.conns, -1)
+}
+
+// Conns returns the current number of live connections to this target.
+func (t *Target) Conns() int64 {
+	return atomic.LoadInt64(&t.conns)
+}
+
+// Observe folds a single response latency into the target's moving average.
+func (t *Target) Observe(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+
+	t.latencyMu.Lock()
+	if t.avgLatencyMs == 0 {
+		t.avgLatencyMs = ms
+	} else {
+		t.avgLatencyMs = latencyEWMAWeight*ms + (1-latencyEWMAWeight)*t.avgLatencyMs
+	}
+	t.latencyMu.Unlock()
+}
+
+// AvgLatency returns the current moving-average response latency, in milliseconds.
+func (t *Target) AvgLatency() float64 {
+	t.latencyMu.Lock()
+	defer t.latencyMu.Unlock()
+	return t.avgLatencyMs
 }
 
-func (t Target) url() *url.URL {
+func (t *Target) url() *url.URL {
 	s := fmt.Sprintf("http://%s:%d", t.TaskIP, t.TaskPort)
 	u, err := url.Parse(s)
 	if err != nil {
@@ -267,7 +352,7 @@ type TargetChangeEvent struct {
 	Target
 }
 
-func (ev TargetChangeEvent) String() string {
+func (ev *TargetChangeEvent) String() string {
 	return fmt.Sprintf("{%s: app:%s task:%s ip:%s:%d weight:%f}",
 		ev.Change, ev.AppID, ev.TaskID, ev.TaskIP, ev.TaskPort, ev.Weight)
 }
@@ -0,0 +1,311 @@
+package scheduler
+
+import (
+	"fmt"
+
+	"github.com/Dataman-Cloud/swan/src/mesosproto/mesos"
+	"github.com/Dataman-Cloud/swan/src/types"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// FilterResources narrows an offer's resources down to those this framework
+// may actually use: resources reserved for role, plus unreserved ("*")
+// resources. Role-matching, dynamically-reserved resources are returned
+// first so BuildTaskInfo consumes them ahead of the unreserved pool.
+func FilterResources(resources []*mesos.Resource, role string) []*mesos.Resource {
+	if role == "" || role == "*" {
+		return resources
+	}
+
+	var reserved, unreserved []*mesos.Resource
+	for _, r := range resources {
+		resRole := "*"
+		if r.Role != nil {
+			resRole = *r.Role
+		}
+
+		switch resRole {
+		case role:
+			reserved = append(reserved, r)
+		case "*":
+			unreserved = append(unreserved, r)
+		}
+	}
+
+	return append(reserved, unreserved...)
+}
+
+// reservePortRanges picks n host ports out of offer's "ports" resource,
+// starting past however many ports this Scheduler has already claimed from
+// this same offer this round (s.PortsLaunched). Offsetting by task count
+// (s.TaskLaunched) isn't enough: a version with more than one port mapping
+// would still overlap with the next task's reservation, since each task
+// consumes n ports, not 1. Without this offset, two tasks built from one
+// offer (or a task group's members) would each reserve the identical
+// leading ports and collide. It returns an error rather than letting the
+// task launch with fewer ports than it declared.
+func (s *Scheduler) reservePortRanges(offer *mesos.Offer, n int) ([]uint64, error) {
+	if n == 0 {
+		return nil, nil
+	}
+
+	ports := GetPorts(offer)
+	offset := int(s.PortsLaunched)
+	if len(ports) < offset+n {
+		return nil, fmt.Errorf("offer %s has %d available ports, %d already claimed this round, task needs %d",
+			*offer.GetId().Value, len(ports), offset, n)
+	}
+
+	s.PortsLaunched += uint32(n)
+	return ports[offset : offset+n], nil
+}
+
+// stripPortsResource drops any "ports" entries from resources. BuildTaskInfo
+// attaches the host ports it actually reserved as its own explicit "ports"
+// Value_RANGES resources; if the offer's full "ports" resource is still
+// sitting in resources at that point (it's part of the filtered offer
+// resources the caller handed in, not something BuildTaskInfo itself
+// carves down), appending on top of it double-counts ports and Mesos
+// rejects the ACCEPT outright.
+func stripPortsResource(resources []*mesos.Resource) []*mesos.Resource {
+	stripped := make([]*mesos.Resource, 0, len(resources))
+	for _, r := range resources {
+		if r.GetName() == "ports" {
+			continue
+		}
+		stripped = append(stripped, r)
+	}
+	return stripped
+}
+
+// resolvePorts returns the n host ports a task should use: explicit, sliced
+// down to n, if the caller already reserved ports on its behalf (a task
+// group member), or a fresh reservation out of offer otherwise.
+func (s *Scheduler) resolvePorts(offer *mesos.Offer, explicit []uint64, n int) ([]uint64, error) {
+	if explicit != nil {
+		if len(explicit) < n {
+			return nil, fmt.Errorf("task group reserved %d ports but a member needs %d", len(explicit), n)
+		}
+		return explicit[:n], nil
+	}
+
+	return s.reservePortRanges(offer, n)
+}
+
+// buildPersistentVolumeOps carves a persistent volume out of the offer's
+// unreserved "disk" resources for every volume in volumes that declares a
+// Persistent id, producing the RESERVE+CREATE operations LaunchTasks must
+// send alongside LAUNCH the first time the volume is bound, the resulting
+// resource list BuildTaskInfo should attach to the task, and the remaining
+// (non-volume) resources still available from the offer. A volume whose
+// Persistence.Id is already reserved/created on this offer (the task is
+// restarting or being rescheduled onto the agent that already holds its
+// volume) is attached as-is instead: re-sending RESERVE/CREATE for it would
+// have Mesos reject the whole ACCEPT as a duplicate reservation.
+func buildPersistentVolumeOps(resources []*mesos.Resource, volumes []*types.Volume, role, principal string) (remaining []*mesos.Resource, volumeResources []*mesos.Resource, ops []*mesos.Offer_Operation, err error) {
+	remaining = resources
+
+	for _, v := range volumes {
+		if v.Persistent == nil {
+			continue
+		}
+
+		if existing, rest := takeExistingVolume(remaining, v.Persistent.Id); existing != nil {
+			remaining = rest
+			volumeResources = append(volumeResources, existing)
+			continue
+		}
+
+		// Mesos rejects a dynamic RESERVE/CREATE under the default "*" role
+		// outright, so a version that asks for a persistent volume without
+		// also setting a real role would otherwise build an operation Mesos
+		// can only reject, taking the whole ACCEPT down with it.
+		if role == "" || role == "*" {
+			return nil, nil, nil, fmt.Errorf("volume %s: persistent volumes require a non-default role, got %q", v.Persistent.Id, role)
+		}
+
+		disk, rest, err := takeDiskResource(remaining, v.Persistent.Size)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("volume %s: %v", v.Persistent.Id, err)
+		}
+		remaining = rest
+
+		mode := mesos.Volume_RO
+		if v.Mode == "RW" {
+			mode = mesos.Volume_RW
+		}
+
+		reserved := &mesos.Resource{
+			Name:   disk.Name,
+			Type:   disk.Type,
+			Scalar: disk.Scalar,
+			Role:   proto.String(role),
+			Reservation: &mesos.Resource_ReservationInfo{
+				Principal: proto.String(principal),
+			},
+		}
+
+		created := &mesos.Resource{
+			Name:        reserved.Name,
+			Type:        reserved.Type,
+			Scalar:      reserved.Scalar,
+			Role:        reserved.Role,
+			Reservation: reserved.Reservation,
+			Disk: &mesos.Resource_DiskInfo{
+				Persistence: &mesos.Resource_DiskInfo_Persistence{
+					Id:        proto.String(v.Persistent.Id),
+					Principal: proto.String(principal),
+				},
+				Volume: &mesos.Volume{
+					ContainerPath: proto.String(v.ContainerPath),
+					Mode:          &mode,
+				},
+			},
+		}
+
+		ops = append(ops,
+			&mesos.Offer_Operation{
+				Type: mesos.Offer_Operation_RESERVE.Enum(),
+				Reserve: &mesos.Offer_Operation_Reserve{
+					Resources: []*mesos.Resource{reserved},
+				},
+			},
+			&mesos.Offer_Operation{
+				Type: mesos.Offer_Operation_CREATE.Enum(),
+				Create: &mesos.Offer_Operation_Create{
+					Volumes: []*mesos.Resource{created},
+				},
+			},
+		)
+
+		volumeResources = append(volumeResources, created)
+	}
+
+	return remaining, volumeResources, ops, nil
+}
+
+// takeExistingVolume looks for a "disk" resource already reserved/created
+// with the given Persistence id among resources (the offer is re-advertising
+// an agent's existing reservation, e.g. after the task that held it was
+// rescheduled), returning it and the remainder with it removed. It returns a
+// nil resource when no such reservation is present on this offer.
+func takeExistingVolume(resources []*mesos.Resource, persistenceID string) (*mesos.Resource, []*mesos.Resource) {
+	for i, r := range resources {
+		if r.GetName() != "disk" || r.Disk == nil || r.Disk.GetPersistence().GetId() != persistenceID {
+			continue
+		}
+
+		remaining := make([]*mesos.Resource, 0, len(resources)-1)
+		remaining = append(remaining, resources[:i]...)
+		remaining = append(remaining, resources[i+1:]...)
+		return r, remaining
+	}
+
+	return nil, resources
+}
+
+// takeDiskResource removes size units of unreserved "disk" from resources,
+// returning the carved-out resource and the remainder. It errors when the
+// offer doesn't carry enough unreserved disk, rather than silently creating
+// a persistent volume smaller than requested.
+func takeDiskResource(resources []*mesos.Resource, size float64) (*mesos.Resource, []*mesos.Resource, error) {
+	for i, r := range resources {
+		if r.GetName() != "disk" || r.Disk != nil || r.GetScalar().GetValue() < size {
+			continue
+		}
+		if r.Reservation != nil || (r.Role != nil && *r.Role != "*") {
+			continue
+		}
+
+		taken := &mesos.Resource{
+			Name: proto.String("disk"),
+			Type: mesos.Value_SCALAR.Enum(),
+			Scalar: &mesos.Value_Scalar{
+				Value: proto.Float64(size),
+			},
+		}
+
+		remaining := make([]*mesos.Resource, 0, len(resources))
+		remaining = append(remaining, resources[:i]...)
+		remaining = append(remaining, resources[i+1:]...)
+
+		if leftover := r.GetScalar().GetValue() - size; leftover > 0 {
+			remaining = append(remaining, &mesos.Resource{
+				Name:   r.Name,
+				Type:   r.Type,
+				Role:   r.Role,
+				Scalar: &mesos.Value_Scalar{Value: proto.Float64(leftover)},
+			})
+		}
+
+		return taken, remaining, nil
+	}
+
+	return nil, nil, fmt.Errorf("offer has no unreserved disk resource of at least %.2f MB", size)
+}
+
+// splitMemberResources carves cpus/mem/disk sized to task's own declared
+// share out of a task group's pooled resources, so each member claims only
+// its own slice of the offer instead of every member receiving the whole
+// filtered resource list BuildTaskGroupInfo started with.
+func splitMemberResources(resources []*mesos.Resource, task *types.Task) ([]*mesos.Resource, []*mesos.Resource, error) {
+	var member []*mesos.Resource
+
+	for _, want := range []struct {
+		name   string
+		amount float64
+	}{
+		{"cpus", task.Cpus},
+		{"mem", task.Mem},
+		{"disk", task.Disk},
+	} {
+		if want.amount <= 0 {
+			continue
+		}
+
+		taken, rest, err := takeScalarResource(resources, want.name, want.amount)
+		if err != nil {
+			return nil, nil, err
+		}
+		resources = rest
+		member = append(member, taken)
+	}
+
+	return member, resources, nil
+}
+
+// takeScalarResource removes amount units of an unreserved scalar resource
+// named name from resources, returning the carved-out resource and the
+// remainder.
+func takeScalarResource(resources []*mesos.Resource, name string, amount float64) (*mesos.Resource, []*mesos.Resource, error) {
+	for i, r := range resources {
+		if r.GetName() != name || r.Reservation != nil || r.GetScalar().GetValue() < amount {
+			continue
+		}
+
+		taken := &mesos.Resource{
+			Name:   proto.String(name),
+			Type:   mesos.Value_SCALAR.Enum(),
+			Role:   r.Role,
+			Scalar: &mesos.Value_Scalar{Value: proto.Float64(amount)},
+		}
+
+		remaining := make([]*mesos.Resource, 0, len(resources))
+		remaining = append(remaining, resources[:i]...)
+		remaining = append(remaining, resources[i+1:]...)
+
+		if leftover := r.GetScalar().GetValue() - amount; leftover > 0 {
+			remaining = append(remaining, &mesos.Resource{
+				Name:   r.Name,
+				Type:   r.Type,
+				Role:   r.Role,
+				Scalar: &mesos.Value_Scalar{Value: proto.Float64(leftover)},
+			})
+		}
+
+		return taken, remaining, nil
+	}
+
+	return nil, nil, fmt.Errorf("offer has no unreserved %s resource of at least %.2f", name, amount)
+}
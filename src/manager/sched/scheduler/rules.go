@@ -0,0 +1,196 @@
+package scheduler
+
+import (
+	"context"
+	"flag"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/src/mesosproto/sched"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// silentFlag backs the default SilentRule registered by New (see
+// scheduler.go); pass -silent to suppress routine ACCEPT/DECLINE/REVIVE call
+// logging while still logging errors.
+var silentFlag = flag.Bool("silent", false, "suppress routine scheduler call logging")
+
+// Rule is a chain-of-responsibility middleware wrapped around every outbound
+// sched.Call. next invokes the rest of the chain and, eventually, the real
+// transport; a Rule may inspect or rewrite call before calling next, decide
+// not to call it at all, or post-process the response/error it returns.
+// Cross-cutting concerns (metrics, tracing, audit, quota enforcement) should
+// be implemented as a Rule and registered with Scheduler.Use instead of
+// forking send().
+type Rule func(ctx context.Context, call *sched.Call, next func(context.Context, *sched.Call) (*http.Response, error)) (*http.Response, error)
+
+// EventRule is the inbound-event analogue of Rule, run on the status-update
+// path before an event reaches Scheduler's own handling.
+type EventRule func(ctx context.Context, event *sched.Event, next func(context.Context, *sched.Event) error) error
+
+// Use registers additional call rules, run in the order given, outermost
+// first. Intended to be called once at framework startup.
+func (s *Scheduler) Use(rules ...Rule) {
+	s.rules = append(s.rules, rules...)
+}
+
+// UseEvent registers additional event rules, run in the order given,
+// outermost first. Intended to be called once at framework startup.
+func (s *Scheduler) UseEvent(rules ...EventRule) {
+	s.eventRules = append(s.eventRules, rules...)
+}
+
+// runRules threads call through every registered Rule before terminal (the
+// real transport) runs.
+func (s *Scheduler) runRules(ctx context.Context, call *sched.Call, terminal func(context.Context, *sched.Call) (*http.Response, error)) (*http.Response, error) {
+	next := terminal
+	for i := len(s.rules) - 1; i >= 0; i-- {
+		rule, rest := s.rules[i], next
+		next = func(ctx context.Context, call *sched.Call) (*http.Response, error) {
+			return rule(ctx, call, rest)
+		}
+	}
+	return next(ctx, call)
+}
+
+// runEventRules threads event through every registered EventRule before
+// terminal (Scheduler's own event handling) runs.
+func (s *Scheduler) runEventRules(ctx context.Context, event *sched.Event, terminal func(context.Context, *sched.Event) error) error {
+	next := terminal
+	for i := len(s.eventRules) - 1; i >= 0; i-- {
+		rule, rest := s.eventRules[i], next
+		next = func(ctx context.Context, event *sched.Event) error {
+			return rule(ctx, event, rest)
+		}
+	}
+	return next(ctx, event)
+}
+
+// callCounters tallies outbound calls by type for MetricsRule, exposed via
+// CallCounts for the admin stats endpoint.
+var callCounters sync.Map // map[sched.Call_Type]*int64
+
+// CallCounts returns a snapshot of how many calls of each type MetricsRule
+// has observed since startup.
+func CallCounts() map[string]int64 {
+	ret := make(map[string]int64)
+	callCounters.Range(func(k, v interface{}) bool {
+		ret[k.(sched.Call_Type).String()] = atomic.LoadInt64(v.(*int64))
+		return true
+	})
+	return ret
+}
+
+// MetricsRule tallies every outbound call by type.
+func MetricsRule(ctx context.Context, call *sched.Call, next func(context.Context, *sched.Call) (*http.Response, error)) (*http.Response, error) {
+	counter, _ := callCounters.LoadOrStore(call.GetType(), new(int64))
+	atomic.AddInt64(counter.(*int64), 1)
+
+	return next(ctx, call)
+}
+
+// silenceKey is the context key NewSilentRule uses to tell LoggingRule (or
+// any downstream rule) that routine logging for this call should be skipped.
+type silenceKey struct{}
+
+func isSilenced(ctx context.Context) bool {
+	silent, _ := ctx.Value(silenceKey{}).(bool)
+	return silent
+}
+
+// LoggingRule logs every outbound call and its outcome at debug level, unless
+// NewSilentRule has silenced this particular call. Errors are always logged.
+func LoggingRule(ctx context.Context, call *sched.Call, next func(context.Context, *sched.Call) (*http.Response, error)) (*http.Response, error) {
+	if !isSilenced(ctx) {
+		logrus.Debugf("sending %s call to mesos", call.GetType())
+	}
+
+	resp, err := next(ctx, call)
+	if err != nil {
+		logrus.Errorf("%s call failed: %v", call.GetType(), err)
+	}
+
+	return resp, err
+}
+
+// NewSilentRule builds a Rule that marks routine call types
+// (ACCEPT/DECLINE/REVIVE) as silenced in the context when silent reports
+// true, e.g. because the framework was started with -silent, so later rules
+// like LoggingRule skip their routine logging for them. Errors are still
+// always logged.
+func NewSilentRule(silent func() bool) Rule {
+	noisy := map[sched.Call_Type]bool{
+		sched.Call_ACCEPT:  true,
+		sched.Call_DECLINE: true,
+		sched.Call_REVIVE:  true,
+	}
+
+	return func(ctx context.Context, call *sched.Call, next func(context.Context, *sched.Call) (*http.Response, error)) (*http.Response, error) {
+		if silent() && noisy[call.GetType()] {
+			ctx = context.WithValue(ctx, silenceKey{}, true)
+		}
+		return next(ctx, call)
+	}
+}
+
+// NewRateLimitRule builds a Rule that throttles Call_ACCEPT calls to at most
+// one per interval, so an offer storm can't overwhelm mesos with ACCEPT
+// calls faster than the scheduler can reasonably issue them. Other call
+// types pass straight through.
+func NewRateLimitRule(interval time.Duration) Rule {
+	var mu sync.Mutex
+	var last time.Time
+
+	return func(ctx context.Context, call *sched.Call, next func(context.Context, *sched.Call) (*http.Response, error)) (*http.Response, error) {
+		if call.GetType() != sched.Call_ACCEPT {
+			return next(ctx, call)
+		}
+
+		// each caller claims the next free slot under the lock, so concurrent
+		// ACCEPTs are spaced interval apart instead of all waking together.
+		mu.Lock()
+		now := time.Now()
+		slot := last.Add(interval)
+		if slot.Before(now) {
+			slot = now
+		}
+		last = slot
+		mu.Unlock()
+
+		time.Sleep(slot.Sub(now))
+
+		return next(ctx, call)
+	}
+}
+
+// NewRetryRule builds a Rule that makes up to maxAttempts attempts at a call
+// (the first try plus maxAttempts-1 retries) while it returns an error, with
+// jittered exponential backoff starting at baseDelay.
+func NewRetryRule(maxAttempts int, baseDelay time.Duration) Rule {
+	return func(ctx context.Context, call *sched.Call, next func(context.Context, *sched.Call) (*http.Response, error)) (*http.Response, error) {
+		var resp *http.Response
+		var err error
+
+		for attempt := 0; attempt < maxAttempts; attempt++ {
+			resp, err = next(ctx, call)
+			if err == nil {
+				return resp, nil
+			}
+
+			if attempt == maxAttempts-1 {
+				break
+			}
+
+			delay := baseDelay * time.Duration(1<<uint(attempt))
+			jitter := time.Duration(rand.Int63n(int64(delay) / 2))
+			logrus.Warnf("%s call failed (attempt %d/%d), retrying in %s: %v", call.GetType(), attempt+1, maxAttempts, delay+jitter, err)
+			time.Sleep(delay + jitter)
+		}
+
+		return resp, err
+	}
+}
@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"context"
+
+	"github.com/Dataman-Cloud/swan/src/janitor"
+	"github.com/Dataman-Cloud/swan/src/mesosproto/mesos"
+	"github.com/Dataman-Cloud/swan/src/mesosproto/sched"
+	"github.com/Dataman-Cloud/swan/src/types"
+)
+
+// HandleEvent runs an inbound Mesos event through the registered EventRules
+// before dispatching it to Scheduler's own handling.
+func (s *Scheduler) HandleEvent(ctx context.Context, event *sched.Event) error {
+	return s.runEventRules(ctx, event, s.dispatchEvent)
+}
+
+// dispatchEvent is the terminal handler at the end of the event-rule chain:
+// it's where status updates actually get acted on, once every registered
+// EventRule (metrics, logging, ...) has had a look.
+func (s *Scheduler) dispatchEvent(_ context.Context, event *sched.Event) error {
+	if event.GetType() != sched.Event_UPDATE {
+		return nil
+	}
+
+	status := event.GetUpdate().GetStatus()
+
+	task, err := s.store.FetchTask(status.GetTaskId().GetValue())
+	if err != nil || task == nil {
+		return err
+	}
+
+	s.handleUserNetworkStatusUpdate(status, task)
+	return nil
+}
+
+// handleUserNetworkStatusUpdate inspects a task status update for a CNI-assigned
+// container IP and, if found, records it on the task and republishes the
+// janitor target so traffic is proxied straight to the container instead of
+// the agent hostname.
+func (s *Scheduler) handleUserNetworkStatusUpdate(status *mesos.TaskStatus, task *types.Task) {
+	ip := taskIPFromStatus(status)
+	if ip == "" || ip == task.IP {
+		return
+	}
+
+	task.IP = ip
+
+	s.TargetChangeCh <- &janitor.TargetChangeEvent{
+		Change: "update",
+		Target: janitor.Target{
+			AppID:      task.AppId,
+			AppVersion: task.VersionId,
+			TaskID:     task.ID,
+			TaskIP:     ip,
+		},
+	}
+}
+
+// taskIPFromStatus extracts the first CNI-assigned container IP Mesos reports
+// for a task launched on the USER network, from
+// TaskStatus.ContainerStatus.NetworkInfos[].IpAddresses[]. It returns "" when
+// the task isn't on a CNI network or Mesos hasn't reported an address yet.
+func taskIPFromStatus(status *mesos.TaskStatus) string {
+	if status.ContainerStatus == nil {
+		return ""
+	}
+
+	for _, networkInfo := range status.ContainerStatus.NetworkInfos {
+		for _, addr := range networkInfo.IpAddresses {
+			if addr.IpAddress != nil && *addr.IpAddress != "" {
+				return *addr.IpAddress
+			}
+		}
+	}
+
+	return ""
+}
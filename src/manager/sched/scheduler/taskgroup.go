@@ -0,0 +1,173 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/src/mesosproto/mesos"
+	"github.com/Dataman-Cloud/swan/src/mesosproto/sched"
+	"github.com/Dataman-Cloud/swan/src/types"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/golang/protobuf/proto"
+)
+
+// BuildTaskGroup builds one types.Task per sidecar declared in
+// version.TaskGroup for a single pod instance named name, ready to be passed
+// to BuildTaskGroupInfo. Every member shares the pod's volumes and kill
+// policy, so the group starts and stops as a unit.
+func (s *Scheduler) BuildTaskGroup(offer *mesos.Offer, version *types.Version, name string) ([]*types.Task, error) {
+	if len(version.TaskGroup) == 0 {
+		return nil, fmt.Errorf("version %s declares no task group", version.AppId)
+	}
+
+	tasks := make([]*types.Task, 0, len(version.TaskGroup))
+	for _, spec := range version.TaskGroup {
+		tasks = append(tasks, s.buildGroupMemberTask(offer, version, spec, name))
+	}
+
+	return tasks, nil
+}
+
+// buildGroupMemberTask builds a single sidecar's Task from its ContainerSpec,
+// inheriting the pod-level volumes, role and kill policy declared on version
+// so every member of the group is placed and torn down the same way.
+func (s *Scheduler) buildGroupMemberTask(offer *mesos.Offer, version *types.Version, spec *types.ContainerSpec, name string) *types.Task {
+	var task types.Task
+
+	task.Name = fmt.Sprintf("%s.%s", spec.Name, name)
+	task.ID = fmt.Sprintf("%d-%s", time.Now().UnixNano(), task.Name)
+	task.AppId = version.AppId
+
+	task.Image = spec.Image
+	task.Env = spec.Env
+	task.Volumes = version.Container.Volumes
+
+	task.Cpus = spec.Cpus
+	task.Mem = spec.Mem
+	task.Disk = spec.Disk
+
+	// A member can declare its own network mode (e.g. a sidecar exposing its
+	// own BRIDGE/USER ports); falling back to the pod's own network keeps
+	// the common case - every member sharing the one namespace the group
+	// was launched for - a one-line declaration instead of per-member
+	// boilerplate. Either way this must be set for buildTaskInfo to exercise
+	// its BRIDGE/SWAN_RESERVED_NETWORK branches and actually spend the ports
+	// BuildTaskGroupInfo reserved for this member.
+	task.Network = spec.Network
+	if task.Network == "" {
+		task.Network = version.Container.Docker.Network
+	}
+
+	for _, portMapping := range spec.PortMappings {
+		task.PortMappings = append(task.PortMappings, &types.PortMappings{
+			Port:     uint32(portMapping.ContainerPort),
+			Protocol: portMapping.Protocol,
+			Name:     portMapping.Name,
+		})
+	}
+
+	task.OfferId = *offer.GetId().Value
+	task.AgentId = *offer.AgentId.Value
+	task.AgentHostname = *offer.Hostname
+
+	task.KillPolicy = version.KillPolicy
+	task.Role = version.Role
+	if task.Role == "" {
+		task.Role = "*"
+	}
+
+	return &task
+}
+
+// BuildTaskGroupInfo builds a single mesos.TaskGroupInfo for a pod of
+// co-scheduled tasks that must share a network namespace and volumes and be
+// killed atomically. Ports are reserved once across the whole group rather
+// than per member, since every member is carved out of the same offer; cpu
+// and mem are likewise split per member's own declared share (BuildTaskGroup
+// copied each ContainerSpec's Cpus/Mem/Disk onto its Task) so members don't
+// each claim the group's entire resource pool.
+func (s *Scheduler) BuildTaskGroupInfo(offer *mesos.Offer, resources []*mesos.Resource, tasks []*types.Task) (*mesos.TaskGroupInfo, []*mesos.Offer_Operation, error) {
+	totalPorts := 0
+	for _, task := range tasks {
+		totalPorts += len(task.PortMappings)
+	}
+
+	ports, err := s.reservePortRanges(offer, totalPorts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		taskInfos []*mesos.TaskInfo
+		ops       []*mesos.Offer_Operation
+		remaining = resources
+	)
+
+	for _, task := range tasks {
+		n := len(task.PortMappings)
+
+		memberResources, rest, err := splitMemberResources(remaining, task)
+		if err != nil {
+			return nil, nil, fmt.Errorf("task group member %s: %v", task.Name, err)
+		}
+		remaining = rest
+
+		taskInfo, taskOps, err := s.buildTaskInfo(offer, memberResources, task, ports[:n])
+		if err != nil {
+			return nil, nil, fmt.Errorf("task group member %s: %v", task.Name, err)
+		}
+		ports = ports[n:]
+
+		taskInfos = append(taskInfos, taskInfo)
+		ops = append(ops, taskOps...)
+	}
+
+	return &mesos.TaskGroupInfo{Tasks: taskInfos}, ops, nil
+}
+
+// LaunchTaskGroup launches a pod of co-scheduled tasks via
+// Offer_Operation_LAUNCH_GROUP so they start and are killed atomically under
+// a single, shared ExecutorInfo of Type DEFAULT. The DEFAULT executor is
+// Mesos's own built-in one: declaring it is enough for the agent to run
+// every task in taskGroup inside one shared network namespace and mount
+// namespace, without swan needing to supply executor code of its own.
+func (s *Scheduler) LaunchTaskGroup(offer *mesos.Offer, taskGroup *mesos.TaskGroupInfo, extraOps []*mesos.Offer_Operation) (*http.Response, error) {
+	logrus.Infof("Launch task group of %d tasks with offer %s", len(taskGroup.Tasks), *offer.GetId().Value)
+
+	executorInfo := &mesos.ExecutorInfo{
+		ExecutorId: &mesos.ExecutorID{
+			Value: proto.String("swan-group-executor-" + *offer.GetId().Value),
+		},
+		FrameworkId: s.framework.GetId(),
+		Type:        mesos.ExecutorInfo_DEFAULT.Enum(),
+	}
+
+	operations := append(extraOps, &mesos.Offer_Operation{
+		Type: mesos.Offer_Operation_LAUNCH_GROUP.Enum(),
+		LaunchGroup: &mesos.Offer_Operation_LaunchGroup{
+			Executor:  executorInfo,
+			TaskGroup: taskGroup,
+		},
+	})
+
+	call := &sched.Call{
+		FrameworkId: s.framework.GetId(),
+		Type:        sched.Call_ACCEPT.Enum(),
+		Accept: &sched.Call_Accept{
+			OfferIds: []*mesos.OfferID{
+				offer.GetId(),
+			},
+			Operations: operations,
+			Filters:    &mesos.Filters{RefuseSeconds: proto.Float64(1)},
+		},
+	}
+
+	logrus.Debugf("sending LaunchTaskGroup call to mesos, the payload: %s", call.String())
+
+	return s.runRules(context.Background(), call, func(_ context.Context, call *sched.Call) (*http.Response, error) {
+		return s.send(call)
+	})
+}
@@ -1,11 +1,14 @@
 package scheduler
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/Dataman-Cloud/swan/src/executor"
 	"github.com/Dataman-Cloud/swan/src/mesosproto/mesos"
 	"github.com/Dataman-Cloud/swan/src/mesosproto/sched"
 	"github.com/Dataman-Cloud/swan/src/types"
@@ -18,6 +21,36 @@ const (
 	SWAN_RESERVED_NETWORK = "swan"
 )
 
+// ExecutorMode selects how a task's container is run and health-checked.
+const (
+	ExecutorModeDocker = "docker" // default: Docker containerizer, agent-managed health checks
+	ExecutorModeCustom = "custom" // swan executor, in-container health checks & log streaming
+)
+
+// buildExecutorInfo builds the mesos.ExecutorInfo for an ExecutorModeCustom
+// task. The agent fetches the swan executor binary from executorURI (an HTTP
+// endpoint served by the master) and execs it instead of the Docker
+// containerizer's default command, so it can run health checks from inside
+// the container's netns and stream its logs.
+func buildExecutorInfo(task *types.Task, executorURI string) *mesos.ExecutorInfo {
+	return &mesos.ExecutorInfo{
+		ExecutorId: &mesos.ExecutorID{
+			Value: proto.String("swan-executor-" + task.ID),
+		},
+		Command: &mesos.CommandInfo{
+			Shell: proto.Bool(false),
+			Value: proto.String("./swan-executor"),
+			Uris: []*mesos.CommandInfo_URI{
+				{
+					Value:      proto.String(executorURI),
+					Executable: proto.Bool(true),
+					Extract:    proto.Bool(false),
+				},
+			},
+		},
+	}
+}
+
 func (s *Scheduler) BuildTask(offer *mesos.Offer, version *types.Version, name string) (*types.Task, error) {
 	var task types.Task
 
@@ -64,6 +97,12 @@ func (s *Scheduler) BuildTask(offer *mesos.Offer, version *types.Version, name s
 			Key:   "ip",
 			Value: version.Ip[app.Instances],
 		})
+
+		// on the USER (CNI) network the IP isn't a docker run flag, it's a
+		// NetworkInfo label the CNI IPAM plugin honors at launch time.
+		if version.Container.Docker.Network == SWAN_RESERVED_NETWORK {
+			task.IP = version.Ip[app.Instances]
+		}
 	}
 
 	if version.Container.Docker.PortMappings != nil {
@@ -101,11 +140,48 @@ func (s *Scheduler) BuildTask(offer *mesos.Offer, version *types.Version, name s
 		task.HealthChecks = version.HealthChecks
 	}
 
+	task.ExecutorMode = version.ExecutorMode
+	if task.ExecutorMode == "" {
+		task.ExecutorMode = ExecutorModeDocker
+	}
+
+	task.Role = version.Role
+	if task.Role == "" {
+		task.Role = "*"
+	}
+
 	return &task, nil
 }
 
-func (s *Scheduler) BuildTaskInfo(offer *mesos.Offer, resources []*mesos.Resource, task *types.Task) *mesos.TaskInfo {
+// BuildTaskInfo builds the mesos.TaskInfo for task out of the given offer
+// resources. It also returns any Offer_Operations (CREATE/RESERVE) that must
+// be sent alongside the eventual LAUNCH to first bind the task's persistent
+// volumes. resources is filtered down to those usable under task.Role before
+// anything else is built, and launch fails outright if the offer can't cover
+// the task's declared ports rather than silently launching without one.
+func (s *Scheduler) BuildTaskInfo(offer *mesos.Offer, resources []*mesos.Resource, task *types.Task) (*mesos.TaskInfo, []*mesos.Offer_Operation, error) {
+	return s.buildTaskInfo(offer, resources, task, nil)
+}
+
+// buildTaskInfo is BuildTaskInfo's implementation, plus a reservedPorts escape
+// hatch for BuildTaskGroupInfo: a task group reserves its ports once up front
+// for every member, so a member built here must use its slice of that
+// reservation instead of taking its own independent bite out of offer.
+// reservedPorts == nil means "derive them from offer the normal, single-task way".
+func (s *Scheduler) buildTaskInfo(offer *mesos.Offer, resources []*mesos.Resource, task *types.Task, reservedPorts []uint64) (*mesos.TaskInfo, []*mesos.Offer_Operation, error) {
 	logrus.Infof("Prepared task for launch with offer %s", *offer.GetId().Value)
+
+	resources = FilterResources(resources, task.Role)
+
+	var volumeOps []*mesos.Offer_Operation
+	if len(task.Volumes) > 0 {
+		remaining, volumeResources, ops, err := buildPersistentVolumeOps(resources, task.Volumes, task.Role, s.framework.GetPrincipal())
+		if err != nil {
+			return nil, nil, err
+		}
+		resources = append(remaining, volumeResources...)
+		volumeOps = ops
+	}
 	taskInfo := mesos.TaskInfo{
 		Name: proto.String(task.Name),
 		TaskId: &mesos.TaskID{
@@ -136,6 +212,12 @@ func (s *Scheduler) BuildTaskInfo(offer *mesos.Offer, resources []*mesos.Resourc
 	}
 
 	for _, volume := range task.Volumes {
+		if volume.Persistent != nil {
+			// persistent volumes are mounted by the agent itself from the
+			// Resource.DiskInfo attached above, not bind-mounted here.
+			continue
+		}
+
 		mode := mesos.Volume_RO
 		if volume.Mode == "RW" {
 			mode = mesos.Volume_RW
@@ -190,14 +272,19 @@ func (s *Scheduler) BuildTaskInfo(offer *mesos.Offer, resources []*mesos.Resourc
 	case "HOST":
 		taskInfo.Container.Docker.Network = mesos.ContainerInfo_DockerInfo_HOST.Enum()
 	case "BRIDGE":
-		ports := GetPorts(offer)
-		if len(ports) == 0 {
-			logrus.Errorf("No ports resource defined")
-			break
+		ports, err := s.resolvePorts(offer, reservedPorts, len(task.PortMappings))
+		if err != nil {
+			return nil, nil, err
 		}
 
-		for _, m := range task.PortMappings {
-			hostPort := ports[s.TaskLaunched]
+		// the offer's own "ports" resource may still be sitting in
+		// taskInfo.Resources at this point; strip it before appending the
+		// specific host ports this task actually reserved, or Mesos sees
+		// both and rejects the ACCEPT as double-booking ports.
+		taskInfo.Resources = stripPortsResource(taskInfo.Resources)
+
+		for i, m := range task.PortMappings {
+			hostPort := ports[i]
 			taskInfo.Container.Docker.PortMappings = append(taskInfo.Container.Docker.PortMappings,
 				&mesos.ContainerInfo_DockerInfo_PortMapping{
 					HostPort:      proto.Uint32(uint32(hostPort)),
@@ -222,15 +309,72 @@ func (s *Scheduler) BuildTaskInfo(offer *mesos.Offer, resources []*mesos.Resourc
 		taskInfo.Container.Docker.Network = mesos.ContainerInfo_DockerInfo_BRIDGE.Enum()
 	case SWAN_RESERVED_NETWORK:
 		taskInfo.Container.Docker.Network = mesos.ContainerInfo_DockerInfo_USER.Enum()
-		taskInfo.Container.NetworkInfos = append(taskInfo.Container.NetworkInfos, &mesos.NetworkInfo{
+
+		networkInfo := &mesos.NetworkInfo{
 			Name: proto.String(SWAN_RESERVED_NETWORK),
-		})
+		}
+
+		ports, err := s.resolvePorts(offer, reservedPorts, len(task.PortMappings))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// see the BRIDGE case above: don't double-count the offer's own
+		// "ports" resource against the specific ports reserved below.
+		taskInfo.Resources = stripPortsResource(taskInfo.Resources)
+
+		for i, m := range task.PortMappings {
+			hostPort := ports[i]
+			networkInfo.PortMappings = append(networkInfo.PortMappings, &mesos.NetworkInfo_PortMapping{
+				HostPort:      proto.Uint32(uint32(hostPort)),
+				ContainerPort: proto.Uint32(m.Port),
+				Protocol:      proto.String(m.Protocol),
+				Labels: &mesos.Labels{
+					Labels: []*mesos.Label{
+						{Key: proto.String("name"), Value: proto.String(m.Name)},
+					},
+				},
+			})
+
+			taskInfo.Resources = append(taskInfo.Resources, &mesos.Resource{
+				Name: proto.String("ports"),
+				Type: mesos.Value_RANGES.Enum(),
+				Ranges: &mesos.Value_Ranges{
+					Range: []*mesos.Value_Range{
+						{
+							Begin: proto.Uint64(uint64(hostPort)),
+							End:   proto.Uint64(uint64(hostPort)),
+						},
+					},
+				},
+			})
+		}
+
+		// fixed-IP mode: task.IP was pre-assigned in BuildTask, label it so the
+		// CNI IPAM plugin honors the requested address. In dynamic-IP mode
+		// task.IP is empty here and gets filled in later from the CNI-assigned
+		// address reported on the TASK_RUNNING status update.
+		if task.IP != "" {
+			networkInfo.IpAddresses = append(networkInfo.IpAddresses, &mesos.NetworkInfo_IPAddress{
+				IpAddress: proto.String(task.IP),
+			})
+		}
+
+		taskInfo.Container.NetworkInfos = append(taskInfo.Container.NetworkInfos, networkInfo)
 
 	default:
 		taskInfo.Container.Docker.Network = mesos.ContainerInfo_DockerInfo_NONE.Enum()
 
 	}
 
+	// ExecutorModeCustom tasks run the swan executor inside the container
+	// netns, which probes health checks itself and reports them back via
+	// TaskStatus.Message, so the Mesos agent shouldn't also perform them.
+	if task.ExecutorMode == ExecutorModeCustom {
+		taskInfo.Executor = buildExecutorInfo(task, s.ExecutorURI)
+		return &taskInfo, volumeOps, nil
+	}
+
 	if len(task.HealthChecks) > 0 {
 		for _, healthCheck := range task.HealthChecks {
 			if healthCheck.PortIndex < 0 || int(healthCheck.PortIndex) > len(taskInfo.Container.Docker.PortMappings) {
@@ -291,12 +435,22 @@ func (s *Scheduler) BuildTaskInfo(offer *mesos.Offer, resources []*mesos.Resourc
 		}
 	}
 
-	return &taskInfo
+	return &taskInfo, volumeOps, nil
 }
 
-// LaunchTasks lauch multiple tasks with specified offer.
-func (s *Scheduler) LaunchTasks(offer *mesos.Offer, tasks []*mesos.TaskInfo) (*http.Response, error) {
+// LaunchTasks lauch multiple tasks with specified offer. extraOps carries any
+// Offer_Operations (CREATE/RESERVE for persistent volumes) that must precede
+// LAUNCH in the same ACCEPT call, in the order BuildTaskInfo returned them.
+func (s *Scheduler) LaunchTasks(offer *mesos.Offer, tasks []*mesos.TaskInfo, extraOps []*mesos.Offer_Operation) (*http.Response, error) {
 	logrus.Infof("Launch %d tasks with offer %s", len(tasks), *offer.GetId().Value)
+
+	operations := append(extraOps, &mesos.Offer_Operation{
+		Type: mesos.Offer_Operation_LAUNCH.Enum(),
+		Launch: &mesos.Offer_Operation_Launch{
+			TaskInfos: tasks,
+		},
+	})
+
 	call := &sched.Call{
 		FrameworkId: s.framework.GetId(),
 		Type:        sched.Call_ACCEPT.Enum(),
@@ -304,25 +458,34 @@ func (s *Scheduler) LaunchTasks(offer *mesos.Offer, tasks []*mesos.TaskInfo) (*h
 			OfferIds: []*mesos.OfferID{
 				offer.GetId(),
 			},
-			Operations: []*mesos.Offer_Operation{
-				&mesos.Offer_Operation{
-					Type: mesos.Offer_Operation_LAUNCH.Enum(),
-					Launch: &mesos.Offer_Operation_Launch{
-						TaskInfos: tasks,
-					},
-				},
-			},
-			Filters: &mesos.Filters{RefuseSeconds: proto.Float64(1)},
+			Operations: operations,
+			Filters:    &mesos.Filters{RefuseSeconds: proto.Float64(1)},
 		},
 	}
 
 	logrus.Debugf("sending LaunchTasks call to mesos, the payload: %s", call.String())
 
-	return s.send(call)
+	return s.runRules(context.Background(), call, func(_ context.Context, call *sched.Call) (*http.Response, error) {
+		return s.send(call)
+	})
 }
 
+// KillTask asks Mesos to kill task, honoring its own KillPolicy (task.Volumes
+// aside, KillPolicy is the one BuildTask field meant to be overridden per
+// instance rather than inherited wholesale from the version). Mesos's native
+// KillPolicy only carries a grace period, not a choice of signal or a
+// pre-stop hook, so for ExecutorModeCustom tasks those are relayed to the
+// swan executor first via a framework MESSAGE call; it's the executor's own
+// Kill that actually honors Signal and PreStopHook (see src/executor/kill.go).
 func (s *Scheduler) KillTask(task *types.Task) (*http.Response, error) {
 	logrus.Infof("Kill task %s", task.Name)
+
+	if task.ExecutorMode == ExecutorModeCustom {
+		if err := s.sendKillMessage(task); err != nil {
+			logrus.Errorf("failed to relay kill policy to swan executor for task %s: %v", task.Name, err)
+		}
+	}
+
 	call := &sched.Call{
 		FrameworkId: s.framework.GetId(),
 		Type:        sched.Call_KILL.Enum(),
@@ -346,5 +509,46 @@ func (s *Scheduler) KillTask(task *types.Task) (*http.Response, error) {
 		}
 	}
 
-	return s.send(call)
-}
\ No newline at end of file
+	return s.runRules(context.Background(), call, func(_ context.Context, call *sched.Call) (*http.Response, error) {
+		return s.send(call)
+	})
+}
+
+// sendKillMessage relays task's Signal and PreStopHook to the swan executor
+// managing it, so the executor's own Kill can send the requested signal
+// (Mesos's KillPolicy proto has no field for one) and run the hook before
+// signaling. A no-op KillPolicy (nil, or without either field set) isn't
+// worth a round trip.
+func (s *Scheduler) sendKillMessage(task *types.Task) error {
+	kp := task.KillPolicy
+	if kp == nil || (kp.Signal == "" && kp.PreStopHook == "") {
+		return nil
+	}
+
+	data, err := json.Marshal(executor.KillMessage{
+		Signal:      kp.Signal,
+		PreStopHook: kp.PreStopHook,
+	})
+	if err != nil {
+		return err
+	}
+
+	call := &sched.Call{
+		FrameworkId: s.framework.GetId(),
+		Type:        sched.Call_MESSAGE.Enum(),
+		Message: &sched.Call_Message{
+			AgentId: &mesos.AgentID{
+				Value: &task.AgentId,
+			},
+			ExecutorId: &mesos.ExecutorID{
+				Value: proto.String("swan-executor-" + task.ID),
+			},
+			Data: data,
+		},
+	}
+
+	_, err = s.runRules(context.Background(), call, func(_ context.Context, call *sched.Call) (*http.Response, error) {
+		return s.send(call)
+	})
+	return err
+}